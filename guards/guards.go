@@ -0,0 +1,160 @@
+// Package guards detects and inserts C++ "extern C" include guards in C
+// headers: the
+//
+//	#ifdef __cplusplus
+//	extern "C" {
+//	#endif
+//	...
+//	#ifdef __cplusplus
+//	}
+//	#endif
+//
+// idiom nested just inside a header's own #ifndef/#define include guard, so
+// the header can be included from both C and C++ translation units. It is
+// deliberately independent of any CLI so it can be embedded in editor
+// plugins, other linters, or go generate pipelines.
+package guards
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Timmmm/GuardChecker/internal/preproc"
+)
+
+// Options controls what Add inserts.
+type Options struct {
+	// OpenMacro and CloseMacro, if both set, are inserted as a single
+	// literal line each just inside the header's include guard, instead of
+	// the default `#ifdef __cplusplus` / `extern "C" {` idiom. This lets
+	// callers use their own macro pair, e.g. glibc's sys/cdefs.h
+	// BEGIN_C_DECLS/END_C_DECLS, which already expand to the right thing
+	// for both C and C++.
+	OpenMacro  string
+	CloseMacro string
+}
+
+func (o Options) guardText() (open, close string) {
+	if o.OpenMacro != "" || o.CloseMacro != "" {
+		return "\n" + o.OpenMacro + "\n", o.CloseMacro + "\n\n"
+	}
+	return "\n#ifdef __cplusplus\nextern \"C\" {\n#endif\n",
+		"#ifdef __cplusplus\n}\n#endif\n\n"
+}
+
+// validate reports an error if exactly one of OpenMacro/CloseMacro is set,
+// violating the "if both set" contract of the macro pair.
+func (o Options) validate() error {
+	if (o.OpenMacro == "") != (o.CloseMacro == "") {
+		return fmt.Errorf("guards: OpenMacro and CloseMacro must both be set or both be empty, got %q and %q", o.OpenMacro, o.CloseMacro)
+	}
+	return nil
+}
+
+// Result is the outcome of Detect.
+type Result struct {
+	// HasGuards reports whether src already wraps its contents in a C++
+	// extern "C" guard, nested just inside its own #ifndef/#define include
+	// guard.
+	HasGuards bool
+}
+
+// externCRegex and closeBraceRegex recognise the two bits of actual C++
+// code (as opposed to preprocessor directives) that make up an extern "C"
+// block: `extern "C" {` and its closing `}`.
+var externCRegex = regexp.MustCompile(`^extern\s+"C"\s*\{$`)
+var closeBraceRegex = regexp.MustCompile(`^\}$`)
+
+// Detect reports whether src already has a C++ extern "C" include guard.
+//
+// Unlike a line-by-line regex scan, this uses a real preprocessor
+// tokenizer, so it correctly ignores directive-shaped text inside
+// comments, string literals and disabled `#if 0` branches, and isn't
+// confused by headers with more than one #define or #endif.
+func Detect(src []byte) (Result, error) {
+	tree := preproc.BuildTree(preproc.Scan(src))
+	guard := includeGuard(tree)
+	if guard == nil {
+		return Result{}, nil
+	}
+	return Result{HasGuards: hasExternCBlock(src, guard.Branches[0].Body)}, nil
+}
+
+// Add inserts a C++ guard (see Options) just inside src's #ifndef/#define
+// include guard and returns the resulting file contents. It returns an
+// error if src has no such include guard to insert inside.
+func Add(src []byte, opts Options) ([]byte, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	tree := preproc.BuildTree(preproc.Scan(src))
+	guard := includeGuard(tree)
+	if guard == nil {
+		return nil, errors.New("couldn't find an #ifndef/#define include guard")
+	}
+
+	define := guard.Branches[0].Body[0].Directive
+	open, close := opts.guardText()
+
+	var out bytes.Buffer
+	out.Write(src[:define.EndOffset])
+	out.WriteString(open)
+	out.Write(src[define.EndOffset:guard.Close.StartOffset])
+	out.WriteString(close)
+	out.Write(src[guard.Close.StartOffset:])
+	return out.Bytes(), nil
+}
+
+// includeGuard finds the outermost #ifndef FOO / #define FOO / ... /
+// #endif conditional in tree, i.e. the classic include-guard idiom, and
+// returns it. The FOO macro name is guard.Branches[0].Directive.Name. It
+// returns nil if no such conditional exists at the top level.
+func includeGuard(tree []preproc.Node) *preproc.Conditional {
+	for i := range tree {
+		cond := tree[i].Conditional
+		if cond == nil || !cond.Closed || len(cond.Branches) == 0 {
+			continue
+		}
+		branch := cond.Branches[0]
+		if branch.Directive.Kind != preproc.KindIfndef {
+			continue
+		}
+		if len(branch.Body) == 0 || branch.Body[0].Directive == nil {
+			continue
+		}
+		define := branch.Body[0].Directive
+		if define.Kind == preproc.KindDefine && define.Name == branch.Directive.Name {
+			return cond
+		}
+	}
+	return nil
+}
+
+// hasExternCBlock reports whether body contains both halves of an
+// `#ifdef __cplusplus` / `extern "C" {` ... `#ifdef __cplusplus` / `}` pair,
+// in that order, as direct children (so one nested inside a disabled
+// `#if 0` branch doesn't count).
+func hasExternCBlock(src []byte, body []preproc.Node) bool {
+	sawOpen := false
+	for _, node := range body {
+		cond := node.Conditional
+		if cond == nil || !cond.Closed || len(cond.Branches) != 1 {
+			continue
+		}
+		branch := cond.Branches[0]
+		if branch.Directive.Kind != preproc.KindIfdef || branch.Directive.Name != "__cplusplus" {
+			continue
+		}
+		text := strings.TrimSpace(string(src[branch.Directive.EndOffset:cond.Close.StartOffset]))
+		if !sawOpen && externCRegex.MatchString(text) {
+			sawOpen = true
+		} else if sawOpen && closeBraceRegex.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
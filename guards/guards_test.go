@@ -0,0 +1,108 @@
+package guards
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDetectAndAdd(t *testing.T) {
+	const in = `#ifndef FOO_H
+#define FOO_H
+
+int foo(void);
+
+#endif
+`
+	const want = `#ifndef FOO_H
+#define FOO_H
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+int foo(void);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif
+`
+
+	result, err := Detect([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.HasGuards {
+		t.Fatal("HasGuards = true, want false")
+	}
+
+	got, err := Add([]byte(in), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, []byte(want)) {
+		t.Errorf("Add() = %q, want %q", got, want)
+	}
+
+	result, err = Detect(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.HasGuards {
+		t.Error("HasGuards = false after Add, want true")
+	}
+}
+
+func TestAddCustomMacroPair(t *testing.T) {
+	const in = `#ifndef FOO_H
+#define FOO_H
+
+int foo(void);
+
+#endif
+`
+	const want = `#ifndef FOO_H
+#define FOO_H
+
+BEGIN_C_DECLS
+
+int foo(void);
+
+END_C_DECLS
+
+#endif
+`
+
+	got, err := Add([]byte(in), Options{OpenMacro: "BEGIN_C_DECLS", CloseMacro: "END_C_DECLS"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, []byte(want)) {
+		t.Errorf("Add() = %q, want %q", got, want)
+	}
+}
+
+func TestAddNoIncludeGuard(t *testing.T) {
+	if _, err := Add([]byte("#pragma once\n"), Options{}); err == nil {
+		t.Error("Add() on a file with no #ifndef/#define guard = nil error, want non-nil")
+	}
+}
+
+func TestAddOnlyOneMacroSet(t *testing.T) {
+	const in = `#ifndef FOO_H
+#define FOO_H
+
+int foo(void);
+
+#endif
+`
+	for _, opts := range []Options{
+		{OpenMacro: "BEGIN_C_DECLS"},
+		{CloseMacro: "END_C_DECLS"},
+	} {
+		if _, err := Add([]byte(in), opts); err == nil {
+			t.Errorf("Add(%+v) = nil error, want non-nil: only one of OpenMacro/CloseMacro is set", opts)
+		}
+	}
+}
@@ -0,0 +1,453 @@
+// Package preproc implements a small, purely lexical scanner for C/C++
+// preprocessor directives. It is modeled on the byte-level line scanner in
+// go/build/read.go: rather than parsing full C syntax, it just needs to
+// track enough state (comments, string/char literals, backslash line
+// continuations) to reliably tell a real directive from a "#" that merely
+// appears inside a comment, a string, or a disabled #if 0 block.
+package preproc
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Kind identifies which preprocessor directive a Directive represents.
+type Kind int
+
+const (
+	KindInclude Kind = iota
+	KindDefine
+	KindIfdef
+	KindIfndef
+	KindIf
+	KindElif
+	KindElse
+	KindEndif
+	KindPragma
+	KindOther
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindInclude:
+		return "include"
+	case KindDefine:
+		return "define"
+	case KindIfdef:
+		return "ifdef"
+	case KindIfndef:
+		return "ifndef"
+	case KindIf:
+		return "if"
+	case KindElif:
+		return "elif"
+	case KindElse:
+		return "else"
+	case KindEndif:
+		return "endif"
+	case KindPragma:
+		return "pragma"
+	default:
+		return "other"
+	}
+}
+
+var kindByName = map[string]Kind{
+	"include": KindInclude,
+	"define":  KindDefine,
+	"ifdef":   KindIfdef,
+	"ifndef":  KindIfndef,
+	"if":      KindIf,
+	"elif":    KindElif,
+	"else":    KindElse,
+	"endif":   KindEndif,
+	"pragma":  KindPragma,
+}
+
+// Directive is a single preprocessor directive found at column 0 of a
+// logical line (i.e. after any backslash-newline continuations have been
+// joined), outside of comments and string/char literals.
+type Directive struct {
+	Kind Kind
+	// Name is the directive's "subject": the macro name for #define,
+	// #ifdef and #ifndef, or the raw directive word for #pragma and
+	// unrecognized (Other) directives. Empty for #if, #elif, #else and
+	// #endif.
+	Name string
+	// Args is whatever follows Name on the directive line, with leading
+	// and trailing whitespace trimmed. For #include it is empty and the
+	// include spec (e.g. `<stdio.h>` or `"foo.h"`) is in Name instead.
+	// For #if/#elif it holds the condition expression. For #else/#endif
+	// it holds any trailing text (typically a comment, e.g. `// FOO_H`).
+	Args string
+	// StartOffset is the byte offset of the '#' in src.
+	StartOffset int
+	// EndOffset is the byte offset one past the end of the directive's
+	// logical line, including its terminating newline if any.
+	EndOffset int
+	// Line is the 1-based line number of the '#'.
+	Line int
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentByte(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
+
+// Scan walks src and returns every preprocessor directive that appears at
+// column 0 of a logical line outside comments and string/char literals.
+// Directives inside disabled #if 0 branches are still returned - Scan does
+// no conditional evaluation, it only tokenizes; callers that care about
+// which branches are "live" should use BuildTree and walk it themselves.
+func Scan(src []byte) []Directive {
+	const (
+		stateCode = iota
+		stateLineComment
+		stateBlockComment
+		stateString
+		stateChar
+	)
+
+	var directives []Directive
+	state := stateCode
+	canStartDirective := true
+	line := 1
+	i := 0
+	n := len(src)
+
+	for i < n {
+		c := src[i]
+		switch state {
+		case stateCode:
+			switch {
+			case c == '\\' && i+1 < n && src[i+1] == '\n':
+				// Backslash-newline splices the next physical line onto
+				// this one; it never counts as "content" and never ends
+				// the logical line.
+				i += 2
+				line++
+			case c == '\\' && i+2 < n && src[i+1] == '\r' && src[i+2] == '\n':
+				i += 3
+				line++
+			case c == '/' && i+1 < n && src[i+1] == '/':
+				state = stateLineComment
+				i += 2
+			case c == '/' && i+1 < n && src[i+1] == '*':
+				state = stateBlockComment
+				i += 2
+			case c == '"':
+				state = stateString
+				canStartDirective = false
+				i++
+			case c == '\'':
+				state = stateChar
+				canStartDirective = false
+				i++
+			case c == '\n':
+				line++
+				canStartDirective = true
+				i++
+			case c == ' ' || c == '\t' || c == '\r':
+				i++
+			case c == '#' && canStartDirective:
+				d, next, nextLine := scanDirective(src, i, line)
+				directives = append(directives, d)
+				i = next
+				line = nextLine
+				canStartDirective = true
+			default:
+				canStartDirective = false
+				i++
+			}
+
+		case stateLineComment:
+			switch {
+			case c == '\\' && i+1 < n && src[i+1] == '\n':
+				i += 2
+				line++
+			case c == '\n':
+				state = stateCode
+				line++
+				canStartDirective = true
+				i++
+			default:
+				i++
+			}
+
+		case stateBlockComment:
+			switch {
+			case c == '\n':
+				line++
+				i++
+			case c == '*' && i+1 < n && src[i+1] == '/':
+				state = stateCode
+				i += 2
+			default:
+				i++
+			}
+
+		case stateString:
+			switch {
+			case c == '\\' && i+1 < n:
+				i += 2
+			case c == '"':
+				state = stateCode
+				i++
+			case c == '\n':
+				// Unterminated string literal; bail out of it rather than
+				// swallowing the rest of the file.
+				state = stateCode
+				line++
+				i++
+			default:
+				i++
+			}
+
+		case stateChar:
+			switch {
+			case c == '\\' && i+1 < n:
+				i += 2
+			case c == '\'':
+				state = stateCode
+				i++
+			case c == '\n':
+				state = stateCode
+				line++
+				i++
+			default:
+				i++
+			}
+		}
+	}
+
+	return directives
+}
+
+// StripComments returns a copy of src with the contents of every // line
+// comment, /* */ block comment, and "..."/'...' string or char literal
+// replaced with spaces, byte for byte. Newlines and everything outside a
+// comment or literal are left untouched, so line numbers and non-comment
+// text line up exactly with src. It's meant for callers that want to do
+// simple substring or line-based scanning (e.g. heuristics over a header's
+// text) without being fooled by a keyword that only appears inside a
+// comment or string.
+func StripComments(src []byte) []byte {
+	const (
+		stateCode = iota
+		stateLineComment
+		stateBlockComment
+		stateString
+		stateChar
+	)
+
+	out := append([]byte(nil), src...)
+	state := stateCode
+	n := len(src)
+
+	blank := func(i int) {
+		if out[i] != '\n' {
+			out[i] = ' '
+		}
+	}
+
+	for i := 0; i < n; {
+		c := src[i]
+		switch state {
+		case stateCode:
+			switch {
+			case c == '\\' && i+1 < n && src[i+1] == '\n':
+				i += 2
+			case c == '/' && i+1 < n && src[i+1] == '/':
+				state = stateLineComment
+				blank(i)
+				blank(i + 1)
+				i += 2
+			case c == '/' && i+1 < n && src[i+1] == '*':
+				state = stateBlockComment
+				blank(i)
+				blank(i + 1)
+				i += 2
+			case c == '"':
+				state = stateString
+				i++
+			case c == '\'':
+				state = stateChar
+				i++
+			default:
+				i++
+			}
+
+		case stateLineComment:
+			switch {
+			case c == '\\' && i+1 < n && src[i+1] == '\n':
+				i += 2
+			case c == '\n':
+				state = stateCode
+				i++
+			default:
+				blank(i)
+				i++
+			}
+
+		case stateBlockComment:
+			if c == '*' && i+1 < n && src[i+1] == '/' {
+				blank(i)
+				blank(i + 1)
+				state = stateCode
+				i += 2
+			} else {
+				blank(i)
+				i++
+			}
+
+		case stateString:
+			switch {
+			case c == '\\' && i+1 < n:
+				blank(i)
+				blank(i + 1)
+				i += 2
+			case c == '"', c == '\n':
+				state = stateCode
+				i++
+			default:
+				blank(i)
+				i++
+			}
+
+		case stateChar:
+			switch {
+			case c == '\\' && i+1 < n:
+				blank(i)
+				blank(i + 1)
+				i += 2
+			case c == '\'', c == '\n':
+				state = stateCode
+				i++
+			default:
+				blank(i)
+				i++
+			}
+		}
+	}
+
+	return out
+}
+
+// scanDirective parses the directive starting at src[hash] (which must be
+// '#') and returns it along with the byte offset and line number of the
+// position right after the directive's logical line.
+func scanDirective(src []byte, hash int, startLine int) (Directive, int, int) {
+	n := len(src)
+	i := hash + 1
+	line := startLine
+
+	for i < n && (src[i] == ' ' || src[i] == '\t') {
+		i++
+	}
+
+	nameStart := i
+	for i < n && isIdentByte(src[i]) {
+		i++
+	}
+	name := string(src[nameStart:i])
+
+	kind, known := kindByName[name]
+	if !known {
+		kind = KindOther
+	}
+
+	argStart := i
+	for i < n {
+		c := src[i]
+		switch {
+		case c == '\\' && i+1 < n && src[i+1] == '\n':
+			i += 2
+			line++
+		case c == '\\' && i+2 < n && src[i+1] == '\r' && src[i+2] == '\n':
+			i += 3
+			line++
+		case c == '/' && i+1 < n && src[i+1] == '/':
+			i += 2
+			for i < n && src[i] != '\n' {
+				if src[i] == '\\' && i+1 < n && src[i+1] == '\n' {
+					i += 2
+					line++
+					continue
+				}
+				i++
+			}
+		case c == '/' && i+1 < n && src[i+1] == '*':
+			i += 2
+			for i < n && !(src[i] == '*' && i+1 < n && src[i+1] == '/') {
+				if src[i] == '\n' {
+					line++
+				}
+				i++
+			}
+			if i < n {
+				i += 2
+			}
+		case c == '"' || c == '\'':
+			quote := c
+			i++
+			for i < n && src[i] != quote && src[i] != '\n' {
+				if src[i] == '\\' && i+1 < n {
+					i += 2
+					continue
+				}
+				i++
+			}
+			if i < n && src[i] == quote {
+				i++
+			}
+		case c == '\n':
+			goto endOfLine
+		default:
+			i++
+		}
+	}
+endOfLine:
+	argsRaw := src[argStart:i]
+	end := i
+	if end < n && src[end] == '\n' {
+		end++
+		line++
+	}
+
+	d := Directive{
+		Kind:        kind,
+		StartOffset: hash,
+		EndOffset:   end,
+		Line:        startLine,
+	}
+
+	args := strings.TrimSpace(string(bytes.TrimRight(argsRaw, "\r")))
+	switch kind {
+	case KindInclude:
+		d.Name = args
+	case KindDefine, KindIfdef, KindIfndef:
+		d.Name, d.Args = splitMacroName(args)
+	case KindOther:
+		d.Name = name
+		d.Args = args
+	default: // If, Elif, Else, Endif, Pragma
+		d.Args = args
+	}
+
+	return d, end, line
+}
+
+// splitMacroName splits the text following #define/#ifdef/#ifndef into the
+// macro identifier and whatever comes after it (e.g. a function-like
+// macro's parameter list, or a replacement value), trimming whitespace.
+func splitMacroName(s string) (name, rest string) {
+	for i := 0; i < len(s); i++ {
+		if !isIdentByte(s[i]) {
+			return s[:i], strings.TrimSpace(s[i:])
+		}
+	}
+	return s, ""
+}
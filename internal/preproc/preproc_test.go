@@ -0,0 +1,113 @@
+package preproc
+
+import (
+	"strings"
+	"testing"
+)
+
+// kinds returns the Kind of each directive in ds, for compact assertions.
+func kinds(ds []Directive) []Kind {
+	out := make([]Kind, len(ds))
+	for i, d := range ds {
+		out[i] = d.Kind
+	}
+	return out
+}
+
+func assertKinds(t *testing.T, got []Directive, want ...Kind) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("Scan found %d directives %v, want %d %v", len(got), kinds(got), len(want), want)
+	}
+	for i, k := range want {
+		if got[i].Kind != k {
+			t.Errorf("directive %d: Kind = %v, want %v", i, got[i].Kind, k)
+		}
+	}
+}
+
+// TestScanIgnoresDirectiveLikeTextInBlockComments guards against the bug
+// fixed by moving off a line-by-line regex scan: a directive-shaped line
+// inside a /* ... */ comment (e.g. a commented-out extern "C" guard) must
+// not be picked up as a real directive.
+func TestScanIgnoresDirectiveLikeTextInBlockComments(t *testing.T) {
+	const src = `#ifndef FOO_H
+#define FOO_H
+/*
+#ifdef __cplusplus
+extern "C" {
+#endif
+*/
+int foo(void);
+#endif
+`
+	assertKinds(t, Scan([]byte(src)), KindIfndef, KindDefine, KindEndif)
+}
+
+// TestScanIncludesDirectivesInsideDisabledIf0 pins down the documented
+// behaviour that Scan does no conditional evaluation: directives nested
+// inside a disabled `#if 0` branch must still show up in the flat stream
+// (and, via BuildTree, inside that branch's Body) rather than being
+// silently dropped as if the branch were live-evaluated.
+func TestScanIncludesDirectivesInsideDisabledIf0(t *testing.T) {
+	const src = `#if 0
+#define FAKE_H
+#endif
+`
+	directives := Scan([]byte(src))
+	assertKinds(t, directives, KindIf, KindDefine, KindEndif)
+
+	tree := BuildTree(directives)
+	if len(tree) != 1 || tree[0].Conditional == nil {
+		t.Fatalf("BuildTree(%v) = %v, want a single top-level Conditional", directives, tree)
+	}
+	cond := tree[0].Conditional
+	if len(cond.Branches) != 1 || len(cond.Branches[0].Body) != 1 {
+		t.Fatalf("Conditional = %+v, want one branch with one directive in its Body", cond)
+	}
+	if got := cond.Branches[0].Body[0].Directive; got == nil || got.Kind != KindDefine {
+		t.Errorf("disabled branch's Body[0] = %+v, want the #define FAKE_H directive", got)
+	}
+}
+
+// TestScanIgnoresDirectiveLikeTextInErrorString guards against directive
+// words appearing inside a quoted string argument (e.g. a #error message
+// that tells the user to "#define" something) being mistaken for the
+// start of new directives.
+func TestScanIgnoresDirectiveLikeTextInErrorString(t *testing.T) {
+	const src = `#ifndef FOO_H
+#define FOO_H
+#error "please #define FOO_CONFIGURED before #endif including this header"
+#endif
+`
+	directives := Scan([]byte(src))
+	assertKinds(t, directives, KindIfndef, KindDefine, KindOther, KindEndif)
+	if directives[2].Name != "error" {
+		t.Errorf("directives[2].Name = %q, want %q", directives[2].Name, "error")
+	}
+}
+
+// TestScanJoinsBackslashContinuedDirectiveLine verifies that a directive
+// split across physical lines with a trailing backslash is scanned as one
+// logical directive, not split into a directive plus stray trailing text.
+func TestScanJoinsBackslashContinuedDirectiveLine(t *testing.T) {
+	const src = "#define FOO(x) \\\n  ((x) + 1)\n#undef BAR\n"
+
+	directives := Scan([]byte(src))
+	assertKinds(t, directives, KindDefine, KindOther)
+
+	define := directives[0]
+	if define.Name != "FOO" {
+		t.Errorf("define.Name = %q, want %q", define.Name, "FOO")
+	}
+	if define.Line != 1 {
+		t.Errorf("define.Line = %d, want 1", define.Line)
+	}
+	if want := "((x) + 1)"; !strings.Contains(define.Args, want) {
+		t.Errorf("define.Args = %q, want it to contain %q", define.Args, want)
+	}
+
+	if undef := directives[1]; undef.Line != 3 {
+		t.Errorf("undef.Line = %d, want 3 (after the continued #define's two physical lines)", undef.Line)
+	}
+}
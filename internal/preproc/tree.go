@@ -0,0 +1,92 @@
+package preproc
+
+// Node is one entry in a directive tree: either a plain directive (Include,
+// Define, Pragma, Other) or a conditional group (If/Ifdef/Ifndef through its
+// matching Endif, possibly with Elif/Else branches in between).
+type Node struct {
+	// Directive is set for plain, non-conditional directives.
+	Directive *Directive
+	// Conditional is set for #if/#ifdef/#ifndef ... #endif groups.
+	Conditional *Conditional
+}
+
+// Branch is one arm of a Conditional: the directive that introduced it
+// (the opening #if/#ifdef/#ifndef, or a later #elif/#else) and the nodes
+// nested inside it.
+type Branch struct {
+	Directive Directive
+	Body      []Node
+}
+
+// Conditional is a whole #if/#ifdef/#ifndef ... #endif group, including any
+// #elif/#else branches. Close is the zero Directive (Kind == KindEndif with
+// a zero offset) if the file had no matching #endif.
+type Conditional struct {
+	Branches []Branch
+	Close    Directive
+	Closed   bool
+}
+
+// BuildTree pairs up the conditional directives in a flat directive stream
+// (as produced by Scan) into a nested tree, so callers can walk e.g. an
+// #ifndef FOO / #define FOO / #endif include guard as a single unit instead
+// of re-deriving the nesting from a flat list themselves.
+//
+// BuildTree does not evaluate conditions: it nests purely on directive kind,
+// so directives inside a disabled `#if 0` branch still appear in that
+// branch's Body rather than being dropped.
+func BuildTree(directives []Directive) []Node {
+	i := 0
+	return parseNodes(directives, &i)
+}
+
+// parseNodes consumes directives[*i:] until it runs out of input or hits an
+// Elif/Else/Endif that belongs to an enclosing conditional, which it leaves
+// for the caller (parseConditional) to consume.
+func parseNodes(directives []Directive, i *int) []Node {
+	var nodes []Node
+	for *i < len(directives) {
+		d := directives[*i]
+		switch d.Kind {
+		case KindIf, KindIfdef, KindIfndef:
+			nodes = append(nodes, Node{Conditional: parseConditional(directives, i)})
+		case KindElif, KindElse, KindEndif:
+			return nodes
+		default:
+			nodes = append(nodes, Node{Directive: &directives[*i]})
+			*i++
+		}
+	}
+	return nodes
+}
+
+// parseConditional parses one #if/#ifdef/#ifndef group starting at
+// directives[*i], consuming up to and including its matching #endif (if
+// present).
+func parseConditional(directives []Directive, i *int) *Conditional {
+	cond := &Conditional{}
+	branchDirective := directives[*i]
+	*i++
+
+	for {
+		body := parseNodes(directives, i)
+		cond.Branches = append(cond.Branches, Branch{Directive: branchDirective, Body: body})
+
+		if *i >= len(directives) {
+			return cond
+		}
+
+		next := directives[*i]
+		if next.Kind == KindElif || next.Kind == KindElse {
+			branchDirective = next
+			*i++
+			continue
+		}
+		if next.Kind == KindEndif {
+			cond.Close = next
+			cond.Closed = true
+			*i++
+		}
+		return cond
+	}
+}
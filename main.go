@@ -1,220 +1,322 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
-	"regexp"
 	"strings"
+	"syscall"
+
+	"github.com/Timmmm/GuardChecker/guards"
 )
 
-// ScanLinesKeepEnding is a split function for a Scanner that returns each line of
-// text, *without* stripping them of any trailing end-of-line marker.
-// The returned line may be empty. The end-of-line marker is one optional carriage
-// return followed by one mandatory newline. In regular expression notation, it is `\r?\n`.
-// The last non-empty line of input will be returned even if it has no newline.
-func ScanLinesKeepEnding(data []byte, atEOF bool) (advance int, token []byte, err error) {
-	if atEOF && len(data) == 0 {
-		return 0, nil, nil
-	}
-	if i := bytes.IndexByte(data, '\n'); i >= 0 {
-		// We have a full newline-terminated line.
-		return i + 1, data[0 : i+1], nil
-	}
-	// If we're at EOF, we have a final, non-terminated line. Return it.
-	if atEOF {
-		return len(data), data, nil
-	}
-	// Request more data.
-	return 0, nil, nil
+// Action decides what to do once fixFile has worked out whether a header is
+// missing its C++ include guards and, if so, what its contents should look
+// like with them added. Handle is only ever called for files ending in .h.
+type Action interface {
+	// Handle is called once per header. hasGuards reports whether path
+	// already has C++ include guards. fixed is the proposed new contents
+	// if hasGuards is false and fixErr is nil; both are nil if hasGuards
+	// is true. fixErr is non-nil if path is missing guards but
+	// guardchecker couldn't work out how to add them (e.g. it has no
+	// #ifndef/#define include guard to insert inside), in which case
+	// implementations should still treat path as missing guards rather
+	// than silently passing over it.
+	Handle(path string, info os.FileInfo, original, fixed []byte, hasGuards bool, fixErr error) error
 }
 
-// readLines reads a whole file into memory and returns a slice of its lines.
-// Line ending characters are not stripped.
-func readLines(path string) ([]string, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
+// rewriteAction is the default Action: it writes the fixed contents back to
+// disk in place, atomically, optionally keeping a backup of the original.
+type rewriteAction struct {
+	// Backup, if set, saves a copy of the original file to <path>.bak
+	// before rewriting it.
+	Backup bool
+}
+
+func (a rewriteAction) Handle(path string, info os.FileInfo, original, fixed []byte, hasGuards bool, fixErr error) error {
+	if hasGuards || fixErr != nil {
+		return nil
 	}
-	defer file.Close()
+	log.Println("Adding guards to:", path)
 
-	var lines []string
-	scanner := bufio.NewScanner(file)
-	scanner.Split(ScanLinesKeepEnding)
-	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
+	if a.Backup {
+		if err := writeBackupFile(path+".bak", original, info); err != nil {
+			return fmt.Errorf("writing backup file: %w", err)
+		}
+	}
+
+	return atomicWriteFile(path, fixed, info)
+}
+
+// writeBackupFile writes data to path and applies info's permissions (and,
+// where possible, ownership) afterwards, rather than relying on
+// os.WriteFile's mode argument, which is masked by the process umask on
+// creation and so can't be trusted to reproduce info's permissions exactly.
+func writeBackupFile(path string, data []byte, info os.FileInfo) error {
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Chmod(path, info.Mode().Perm()); err != nil {
+		return err
 	}
-	return lines, scanner.Err()
+	preserveOwnership(path, info)
+	return nil
 }
 
-// writeLines writes the lines to the given file. Line ending characters must
-// be included.
-func writeLines(lines []string, path string) error {
-	file, err := os.Create(path)
+// atomicWriteFile replaces path with data without ever leaving it
+// truncated or half-written: it writes to a temporary file in the same
+// directory, fsyncs it, then renames it over path, which is atomic on
+// POSIX. info's permissions (and, where possible, ownership) are applied
+// to the temporary file before the rename so the replacement preserves
+// them rather than picking up the process's umask.
+func atomicWriteFile(path string, data []byte, info os.FileInfo) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	tmpPath := tmp.Name()
+	defer func() {
+		if err != nil {
+			os.Remove(tmpPath)
+		}
+	}()
 
-	w := bufio.NewWriter(file)
-	for _, line := range lines {
-		fmt.Fprint(w, line)
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
 	}
-	return w.Flush()
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Chmod(tmpPath, info.Mode().Perm()); err != nil {
+		return err
+	}
+	preserveOwnership(tmpPath, info)
+
+	return os.Rename(tmpPath, path)
 }
 
-// These are some *very* simple regexes for detecting the include guards.
-var ifdefcppRegex *regexp.Regexp = regexp.MustCompile(`#ifdef\s+__cplusplus(\s+.*)?`)
-var externcRegex *regexp.Regexp = regexp.MustCompile(`extern\s+"C"(\s+.*)?`)
-var endifRegex *regexp.Regexp = regexp.MustCompile(`#endif(\s+.*)?`)
-var closeblockRegex *regexp.Regexp = regexp.MustCompile(`}.*`)
-var defineRegex *regexp.Regexp = regexp.MustCompile(`#define\s+.*`)
-
-// fileHasCppIncludeGuards does a rough check for C++ include guards. It might
-// not always be right if you write them in a weird way (it uses fallible regexes).
-func fileHasCppIncludeGuards(lines []string) bool {
-	// Search for
-	//
-	// #ifdef __cplusplus
-	// extern "C" {
-	// #endif
-	//
-	// and
-	//
-	// #ifdef __cplusplus
-	// }
-	// #endif
-
-	// To keep things simple, we just search for those lines, in that order, ignoring all other lines, using shitty regexes.
-	// This will allow false positives, but you'll really only get them if you write extremely strange code.
-
-	regexes := []*regexp.Regexp{
-		ifdefcppRegex,
-		externcRegex,
-		endifRegex,
-		ifdefcppRegex,
-		closeblockRegex,
-		endifRegex,
-	}
-
-	i := 0
-	for _, line := range lines {
-		if regexes[i].MatchString(strings.TrimSpace(line)) {
-			i += 1
-		}
-		if i >= len(regexes) {
-			return true
-		}
+// preserveOwnership best-efforts applying the uid/gid of info (the file
+// being replaced) to path. It silently does nothing if info's ownership
+// can't be determined (e.g. non-POSIX platforms) or if chown fails (e.g.
+// because we're not root and don't own the file).
+func preserveOwnership(path string, info os.FileInfo) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
 	}
-	return false
+	_ = os.Chown(path, int(stat.Uid), int(stat.Gid))
 }
 
-// addCppIncludeGuards tries to insert C++ include guards just inside the
-// normal header include guards. It does this in a really naive way so check the
-// results! Basically it inserts them after the first #define, and before the last
-// #endif
-//
-// It preserves existing line endings but always uses '\n' on newly inserted lines.
-func addCppIncludeGuards(lines []string) (modifiedLines []string, err error) {
+// checkAction reports missing include guards without modifying anything.
+// Missing is set to true if any file handled was missing guards, so main
+// can turn that into a non-zero exit code.
+type checkAction struct {
+	Missing bool
+}
 
-	modifiedLines = make([]string, 0, len(lines)+8)
+func (a *checkAction) Handle(path string, info os.FileInfo, original, fixed []byte, hasGuards bool, fixErr error) error {
+	if hasGuards {
+		return nil
+	}
+	a.Missing = true
+	if fixErr != nil {
+		fmt.Printf("%s is missing C++ include guards (couldn't add them: %v)\n", path, fixErr)
+		return nil
+	}
+	fmt.Println(path, "is missing C++ include guards")
+	return nil
+}
 
-	// Find the first line matching the #define regex, and the last line matching
-	// the #endif regex.
+// listAction prints the path of every header missing include guards,
+// without modifying anything.
+type listAction struct{}
 
-	firstDefine := -1
-	for i := 0; i < len(lines); i += 1 {
-		if defineRegex.MatchString(strings.TrimSpace(lines[i])) {
-			firstDefine = i
-			break
-		}
+func (listAction) Handle(path string, info os.FileInfo, original, fixed []byte, hasGuards bool, fixErr error) error {
+	if hasGuards {
+		return nil
 	}
+	fmt.Println(path)
+	return nil
+}
 
-	lastEndif := -1
-	for i := len(lines) - 1; i >= 0; i -= 1 {
-		if endifRegex.MatchString(strings.TrimSpace(lines[i])) {
-			lastEndif = i
-			break
-		}
+// diffAction prints a unified diff of the proposed changes to stdout,
+// without modifying anything.
+type diffAction struct{}
+
+func (diffAction) Handle(path string, info os.FileInfo, original, fixed []byte, hasGuards bool, fixErr error) error {
+	if hasGuards {
+		return nil
+	}
+	if fixErr != nil {
+		fmt.Fprintf(os.Stderr, "%s is missing C++ include guards but couldn't be fixed: %v\n", path, fixErr)
+		return nil
 	}
+	return printUnifiedDiff(os.Stdout, path, original, fixed)
+}
 
-	if firstDefine == -1 {
-		err = errors.New("Couldn't find first #define")
-		return
+// printUnifiedDiff writes a unified diff between original and fixed to w,
+// labelled with path. It shells out to the system `diff` tool rather than
+// reimplementing one.
+func printUnifiedDiff(w io.Writer, path string, original, fixed []byte) error {
+	origFile, err := os.CreateTemp("", "guardchecker-orig-*.h")
+	if err != nil {
+		return err
 	}
+	defer os.Remove(origFile.Name())
+	defer origFile.Close()
 
-	if lastEndif == -1 {
-		err = errors.New("Couldn't find last #endif")
-		return
+	fixedFile, err := os.CreateTemp("", "guardchecker-fixed-*.h")
+	if err != nil {
+		return err
 	}
+	defer os.Remove(fixedFile.Name())
+	defer fixedFile.Close()
 
-	for i, line := range lines {
-		if i == firstDefine {
-			// Add a couple of lines afterwards...
-			modifiedLines = append(modifiedLines, line)
-			modifiedLines = append(modifiedLines, "\n")
-			modifiedLines = append(modifiedLines, "#ifdef __cplusplus\n")
-			modifiedLines = append(modifiedLines, "extern \"C\" {\n")
-			modifiedLines = append(modifiedLines, "#endif\n")
-		} else if i == lastEndif {
-			modifiedLines = append(modifiedLines, "#ifdef __cplusplus\n")
-			modifiedLines = append(modifiedLines, "}\n")
-			modifiedLines = append(modifiedLines, "#endif\n")
-			modifiedLines = append(modifiedLines, "\n")
-			modifiedLines = append(modifiedLines, line)
-		} else {
-			modifiedLines = append(modifiedLines, line)
+	if _, err := origFile.Write(original); err != nil {
+		return err
+	}
+	if _, err := fixedFile.Write(fixed); err != nil {
+		return err
+	}
+
+	cmd := exec.Command("diff", "-u",
+		"--label", path,
+		"--label", path,
+		origFile.Name(), fixedFile.Name())
+	out, err := cmd.Output()
+	if err != nil {
+		// diff exits with status 1 when the inputs differ, which is the
+		// expected case here, not a failure.
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+			return err
 		}
 	}
-	return
+	_, err = w.Write(out)
+	return err
 }
 
-// fixFile checks if a file is a C header (i.e. ends in .h). If so
-// it scans the file for C++ include guards and adds them if absent.
-func fixFile(path string, info os.FileInfo, err error) error {
+// fixFile checks if a file is a C header (i.e. ends in .h). If so, it uses
+// the guards package to detect and add C++ include guards, and hands the
+// original and proposed contents to action to decide what to do about it.
+func fixFile(path string, info os.FileInfo, err error, action Action) error {
 	// If `err` is not nil then there was an error walking to the file
 	// named by `path`.
 
-	if strings.HasSuffix(info.Name(), ".h") {
-		lines, err := readLines(path)
-		if err != nil {
-			log.Println("Error reading file:", err)
-			return nil
-		}
+	if !strings.HasSuffix(info.Name(), ".h") {
+		return nil
+	}
 
-		hasGuards := fileHasCppIncludeGuards(lines)
+	src, err := os.ReadFile(path)
+	if err != nil {
+		log.Println("Error reading file:", err)
+		return nil
+	}
 
-		if !hasGuards {
-			log.Println("Adding guards to:", path)
-			modifiedLines, err := addCppIncludeGuards(lines)
-			if err != nil {
-				log.Println("Error adding include guards:", err)
-				return nil
-			}
+	if skipComment.Match(src) || looksLikeCpp(src) {
+		return nil
+	}
 
-			err = writeLines(modifiedLines, path)
-			if err != nil {
-				log.Println("Error writing to file:", err)
-				return nil
-			}
+	result, err := guards.Detect(src)
+	if err != nil {
+		log.Println("Error detecting include guards:", err)
+		return nil
+	}
+
+	var fixed []byte
+	var fixErr error
+	if !result.HasGuards {
+		fixed, fixErr = guards.Add(src, guards.Options{})
+		if fixErr != nil {
+			log.Println("Error adding include guards:", fixErr)
 		}
 	}
 
+	if err := action.Handle(path, info, src, fixed, result.HasGuards, fixErr); err != nil {
+		log.Println("Error handling file:", err)
+	}
+
 	// If we return an error, processing stops.
 	return nil
 }
 
 func main() {
+	checkFlag := flag.Bool("check", false, "exit non-zero if any .h file is missing C++ include guards, without modifying files")
+	diffFlag := flag.Bool("diff", false, "print a unified diff of the proposed changes to stdout, without modifying files")
+	listFlag := flag.Bool("list", false, "print the paths of .h files missing C++ include guards, without modifying files")
+	backupFlag := flag.Bool("backup", false, "when rewriting a file, first save a copy of the original to <path>.bak")
+	excludeFlag := flag.String("exclude", "", "path to a file of filepath.Match globs (one per line, \"**\" matches recursively) of headers to skip")
+	includeFlag := flag.String("include", "", "path to a file of filepath.Match globs (one per line, \"**\" matches recursively); only matching headers are checked")
 	flag.Parse()
 	root := flag.Arg(0)
 
-	err := filepath.Walk(root, fixFile)
+	var action Action
+	check := &checkAction{}
+	switch {
+	case *checkFlag:
+		action = check
+	case *diffFlag:
+		action = diffAction{}
+	case *listFlag:
+		action = listAction{}
+	default:
+		action = rewriteAction{Backup: *backupFlag}
+	}
+
+	var patterns patternSet
+	if *excludeFlag != "" {
+		var err error
+		if patterns.exclude, err = loadPatternFile(*excludeFlag); err != nil {
+			log.Fatalln("Error reading -exclude file:", err)
+		}
+	}
+	if *includeFlag != "" {
+		var err error
+		if patterns.include, err = loadPatternFile(*includeFlag); err != nil {
+			log.Fatalln("Error reading -include file:", err)
+		}
+	}
+	if err := validatePatterns(patterns.exclude); err != nil {
+		log.Fatalln("Invalid -exclude pattern:", err)
+	}
+	if err := validatePatterns(patterns.include); err != nil {
+		log.Fatalln("Invalid -include pattern:", err)
+	}
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		if rel, relErr := filepath.Rel(root, path); relErr == nil {
+			skip, matchErr := patterns.skip(rel)
+			if matchErr != nil {
+				log.Println("Error matching -exclude/-include patterns:", matchErr)
+				return nil
+			}
+			if skip {
+				return nil
+			}
+		}
+
+		return fixFile(path, info, err, action)
+	})
 	if err != nil {
 		log.Println("Error scanning files:", err)
 	}
+
+	if *checkFlag && check.Missing {
+		os.Exit(1)
+	}
 }
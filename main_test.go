@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// guardcheckerBin is the path to the guardchecker binary built once by
+// TestMain, reused by every fixture in TestFixFile.
+var guardcheckerBin string
+
+func TestMain(m *testing.M) {
+	tmpDir, err := os.MkdirTemp("", "guardchecker-build")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	guardcheckerBin = filepath.Join(tmpDir, "guardchecker")
+	cmd := exec.Command("go", "build", "-o", guardcheckerBin, ".")
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, "building guardchecker:", err)
+		os.Exit(1)
+	}
+
+	os.Exit(m.Run())
+}
+
+// TestFixFile runs the guardchecker binary over each testdata/*/*.in.h
+// fixture and checks that it rewrites it to exactly match the matching
+// *.want.h file.
+func TestFixFile(t *testing.T) {
+	fixtures, err := filepath.Glob("testdata/*/*.in.h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatal("no testdata fixtures found")
+	}
+
+	for _, in := range fixtures {
+		in := in
+		name := strings.TrimSuffix(filepath.Base(in), ".in.h")
+		dir := filepath.Dir(in)
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			wantPath := filepath.Join(dir, name+".want.h")
+			want, err := os.ReadFile(wantPath)
+			if err != nil {
+				t.Fatalf("reading %s: %v", wantPath, err)
+			}
+			input, err := os.ReadFile(in)
+			if err != nil {
+				t.Fatalf("reading %s: %v", in, err)
+			}
+
+			workDir := t.TempDir()
+			headerPath := filepath.Join(workDir, name+".h")
+			if err := os.WriteFile(headerPath, input, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			cmd := exec.Command(guardcheckerBin, workDir)
+			cmd.Stderr = os.Stderr
+			if err := cmd.Run(); err != nil {
+				t.Fatalf("running guardchecker: %v", err)
+			}
+
+			got, err := os.ReadFile(headerPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s: output does not match %s:\n%s", in, wantPath, lineDiff(want, got))
+			}
+		})
+	}
+}
+
+// TestCheckFlag verifies that -check reports missing guards via its exit
+// code without modifying the file, and exits zero for a file that already
+// has guards.
+func TestCheckFlag(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		fixture  string
+		wantExit int
+	}{
+		{"missing_guards", "testdata/basic/foo.in.h", 1},
+		{"has_guards", "testdata/already_guarded_weird_whitespace/foo.in.h", 0},
+		{"no_include_guard_to_fix", "testdata/pragma_once/foo.in.h", 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			input, err := os.ReadFile(tc.fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			workDir := t.TempDir()
+			headerPath := filepath.Join(workDir, "foo.h")
+			if err := os.WriteFile(headerPath, input, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			cmd := exec.Command(guardcheckerBin, "-check", workDir)
+			err = cmd.Run()
+			gotExit := 0
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				gotExit = exitErr.ExitCode()
+			} else if err != nil {
+				t.Fatalf("running guardchecker: %v", err)
+			}
+			if gotExit != tc.wantExit {
+				t.Errorf("exit code = %d, want %d", gotExit, tc.wantExit)
+			}
+
+			got, err := os.ReadFile(headerPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, input) {
+				t.Errorf("-check modified the file; got:\n%s", got)
+			}
+		})
+	}
+}
+
+// TestBackupFlag verifies that -backup preserves the original file at
+// <path>.bak and still rewrites <path> itself.
+func TestBackupFlag(t *testing.T) {
+	input, err := os.ReadFile("testdata/basic/foo.in.h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile("testdata/basic/foo.want.h")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	workDir := t.TempDir()
+	headerPath := filepath.Join(workDir, "foo.h")
+	if err := os.WriteFile(headerPath, input, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(guardcheckerBin, "-backup", workDir)
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("running guardchecker: %v", err)
+	}
+
+	got, err := os.ReadFile(headerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("rewritten file = %q, want %q", got, want)
+	}
+
+	backup, err := os.ReadFile(headerPath + ".bak")
+	if err != nil {
+		t.Fatalf("reading backup: %v", err)
+	}
+	if !bytes.Equal(backup, input) {
+		t.Errorf("backup file = %q, want original %q", backup, input)
+	}
+}
+
+// TestBackupFlagPreservesPermissions verifies that the .bak file's mode
+// bits match the original file's, even under a restrictive umask that
+// would otherwise mask them out.
+func TestBackupFlagPreservesPermissions(t *testing.T) {
+	input, err := os.ReadFile("testdata/basic/foo.in.h")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	workDir := t.TempDir()
+	headerPath := filepath.Join(workDir, "foo.h")
+	if err := os.WriteFile(headerPath, input, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	oldUmask := syscall.Umask(0o077)
+	defer syscall.Umask(oldUmask)
+
+	cmd := exec.Command(guardcheckerBin, "-backup", workDir)
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("running guardchecker: %v", err)
+	}
+
+	info, err := os.Stat(headerPath + ".bak")
+	if err != nil {
+		t.Fatalf("stat backup: %v", err)
+	}
+	if got, want := info.Mode().Perm(), os.FileMode(0o644); got != want {
+		t.Errorf("backup file mode = %v, want %v (the original file's mode, regardless of umask)", got, want)
+	}
+}
+
+// TestExcludeFlagAndSkipComment verifies that -exclude skips matching
+// paths and that the "// guardchecker: skip" in-file opt-out is honored,
+// while an ordinary header is still fixed.
+func TestExcludeFlagAndSkipComment(t *testing.T) {
+	input, err := os.ReadFile("testdata/basic/foo.in.h")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "vendor"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "vendor", "foo.h"), input, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "real.h"), input, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	skipped := "#ifndef SKIP_H\n#define SKIP_H\n// guardchecker: skip\nint foo(void);\n#endif\n"
+	if err := os.WriteFile(filepath.Join(root, "skipme.h"), []byte(skipped), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	excludeFile := filepath.Join(root, "exclude.txt")
+	if err := os.WriteFile(excludeFile, []byte("// comment\nvendor/**\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(guardcheckerBin, "-exclude", excludeFile, root)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("running guardchecker: %v\n%s", err, out)
+	}
+
+	vendored, err := os.ReadFile(filepath.Join(root, "vendor", "foo.h"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(vendored, input) {
+		t.Errorf("excluded vendor/foo.h was modified")
+	}
+
+	skippedGot, err := os.ReadFile(filepath.Join(root, "skipme.h"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(skippedGot, []byte(skipped)) {
+		t.Errorf("skipme.h with opt-out comment was modified")
+	}
+
+	real, err := os.ReadFile(filepath.Join(root, "real.h"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(real, input) {
+		t.Errorf("real.h was not fixed")
+	}
+}
+
+// lineDiff returns a minimal line-based diff between want and got, with
+// "-" prefixed lines only in want, "+" prefixed lines only in got, and
+// unprefixed lines common to both.
+func lineDiff(want, got []byte) string {
+	a := splitKeepEnding(want)
+	b := splitKeepEnding(got)
+
+	// Longest common subsequence via the standard O(n*m) DP table; these
+	// fixtures are small enough that this is simpler than pulling in a diff
+	// library.
+	n, m := len(a), len(b)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var out strings.Builder
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			fmt.Fprintf(&out, "  %s", a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			fmt.Fprintf(&out, "- %s", a[i])
+			i++
+		default:
+			fmt.Fprintf(&out, "+ %s", b[j])
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		fmt.Fprintf(&out, "- %s", a[i])
+	}
+	for ; j < m; j++ {
+		fmt.Fprintf(&out, "+ %s", b[j])
+	}
+	return out.String()
+}
+
+// splitKeepEnding splits data into lines, keeping each line's terminator.
+func splitKeepEnding(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			lines = append(lines, string(data))
+			break
+		}
+		lines = append(lines, string(data[:i+1]))
+		data = data[i+1:]
+	}
+	return lines
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/Timmmm/GuardChecker/internal/preproc"
+)
+
+// loadPatternFile reads a -exclude/-include pattern file in the style of
+// errcheck's exclude list: one filepath.Match-style glob per line, blank
+// lines and "//"-prefixed comments ignored.
+func loadPatternFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
+
+// matchGlob reports whether name (a slash-separated relative path) matches
+// pattern, which is interpreted the same way as filepath.Match except that
+// a path segment of "**" additionally matches any number of path segments
+// (including none), for recursive matches like "vendor/**/*.h".
+func matchGlob(pattern, name string) (bool, error) {
+	return matchGlobParts(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchGlobParts(pattern, name []string) (bool, error) {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			if len(pattern) == 1 {
+				return true, nil
+			}
+			for i := 0; i <= len(name); i++ {
+				ok, err := matchGlobParts(pattern[1:], name[i:])
+				if err != nil || ok {
+					return ok, err
+				}
+			}
+			return false, nil
+		}
+		if len(name) == 0 {
+			return false, nil
+		}
+		ok, err := filepath.Match(pattern[0], name[0])
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return false, nil
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0, nil
+}
+
+// patternSet is the parsed form of the -include/-exclude pattern files.
+// A path is skipped if include patterns are given and the path matches
+// none of them, or if it matches any exclude pattern (exclude always wins).
+type patternSet struct {
+	include []string
+	exclude []string
+}
+
+func (p *patternSet) skip(relPath string) (bool, error) {
+	relPath = filepath.ToSlash(relPath)
+
+	if len(p.include) > 0 {
+		matched, err := matchAny(p.include, relPath)
+		if err != nil {
+			return false, err
+		}
+		if !matched {
+			return true, nil
+		}
+	}
+
+	return matchAny(p.exclude, relPath)
+}
+
+// validatePatterns checks that every pattern is syntactically valid,
+// so a typo in an -exclude/-include file is reported once up front instead
+// of as a per-file error that makes every file in the walk look excluded.
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		for _, part := range strings.Split(pattern, "/") {
+			if part == "**" {
+				continue
+			}
+			if _, err := filepath.Match(part, ""); err != nil {
+				return fmt.Errorf("pattern %q: %w", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+func matchAny(patterns []string, relPath string) (bool, error) {
+	for _, pattern := range patterns {
+		ok, err := matchGlob(pattern, relPath)
+		if err != nil {
+			return false, fmt.Errorf("pattern %q: %w", pattern, err)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// skipComment matches the in-file opt-out comment that tells guardchecker
+// to leave a header alone entirely, e.g. `// guardchecker: skip`.
+var skipComment = regexp.MustCompile(`//\s*guardchecker:\s*skip\b`)
+
+// cppTokens are substrings that only show up in C++-only headers, never in
+// plain C ones.
+var cppTokens = []string{"template<", "class ", "namespace ", "#include <string>"}
+
+// looksLikeCpp reports whether src's first 40 non-blank lines of actual code
+// contain anything that marks it as a C++-only header (as opposed to a C
+// header meant to be includable from C++), such headers shouldn't get
+// extern "C" wrappers at all.
+//
+// It scans preproc.StripComments(src) rather than src itself, so a cpp
+// token that only appears inside a comment or string literal - even one
+// starting mid-line, like `int foo(void); /* uses a class internally */` -
+// is never mistaken for real code.
+func looksLikeCpp(src []byte) bool {
+	checked := 0
+
+	for _, rawLine := range bytes.Split(preproc.StripComments(src), []byte("\n")) {
+		line := strings.TrimSpace(string(rawLine))
+		if line == "" {
+			continue
+		}
+
+		checked++
+		for _, tok := range cppTokens {
+			if strings.Contains(line, tok) {
+				return true
+			}
+		}
+		if checked >= 40 {
+			break
+		}
+	}
+	return false
+}
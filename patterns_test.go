@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"*.h", "foo.h", true},
+		{"*.h", "dir/foo.h", false},
+		{"vendor/**", "vendor/lib/foo.h", true},
+		{"vendor/**", "vendor/foo.h", true},
+		{"vendor/**", "src/foo.h", false},
+		{"**/*.h", "a/b/c/foo.h", true},
+		{"**/*.h", "foo.h", true},
+		{"src/*.h", "src/sub/foo.h", false},
+	} {
+		got, err := matchGlob(tc.pattern, tc.name)
+		if err != nil {
+			t.Fatalf("matchGlob(%q, %q): %v", tc.pattern, tc.name, err)
+		}
+		if got != tc.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tc.pattern, tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestLooksLikeCpp(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		src  string
+		want bool
+	}{
+		{"plain_c", "#ifndef FOO_H\n#define FOO_H\nint foo(void);\n#endif\n", false},
+		{"template", "#ifndef FOO_H\n#define FOO_H\ntemplate<typename T>\nT foo(T x);\n#endif\n", true},
+		{"namespace", "#ifndef FOO_H\n#define FOO_H\nnamespace foo {\nint x;\n}\n#endif\n", true},
+		{"class", "#ifndef FOO_H\n#define FOO_H\nclass Foo {};\n#endif\n", true},
+		{"include_string", "#ifndef FOO_H\n#define FOO_H\n#include <string>\n#endif\n", true},
+		{"token_in_comment_ignored", "// class Foo is mentioned here\nint foo(void);\n", false},
+		{"token_in_trailing_comment_ignored", "int run(void); // wraps a C++ class\n", false},
+		{"token_in_midline_block_comment_ignored", "int foo(void); /* uses a class internally */ int bar(void);\n", false},
+		{"slashes_in_string_not_a_comment", `static const char *DOC = "http://example.com"; class Foo {};` + "\n", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := looksLikeCpp([]byte(tc.src)); got != tc.want {
+				t.Errorf("looksLikeCpp(%q) = %v, want %v", tc.src, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidatePatterns(t *testing.T) {
+	if err := validatePatterns([]string{"*.h", "vendor/**", "**/*.h"}); err != nil {
+		t.Errorf("validatePatterns() = %v, want nil", err)
+	}
+	if err := validatePatterns([]string{"vendor/[.h"}); err == nil {
+		t.Error("validatePatterns() with an unterminated character class = nil, want an error")
+	}
+}